@@ -6,15 +6,18 @@ mod websocket_connection;
 
 use actix::clock;
 use actix_cors::Cors;
+use actix_files::Files;
 use actix_web::middleware::Logger;
 use actix_web::web;
 use actix_web_actors::ws;
 use clap::{App, Arg};
 use env_logger;
+use std::fs;
 use std::io::{self, Read};
-use std::process::Command;
+use std::process::{self, Command};
 use std::time::Duration;
 use futures::executor;
+use regex::Regex;
 use std::thread;
 use std::sync::mpsc;
 
@@ -87,9 +90,51 @@ async fn index_js_map(context: web::Data<Context>) -> impl actix_web::Responder
         .body(context.bundled_js_map.clone())
 }
 
+fn cors(socket_address: &str, allowed_origins: &[String]) -> Cors {
+    // With no explicit allowlist, keep the old behavior of accepting any origin.
+    if allowed_origins.is_empty() {
+        return Cors::permissive();
+    }
+
+    // The bundled GUI's websocket handshake sends its own origin, so it always needs to be allowed.
+    let gui_origin = format!("http://{}", socket_address);
+
+    allowed_origins
+        .iter()
+        .fold(Cors::default().allowed_origin(&gui_origin), |cors, origin| {
+            cors.allowed_origin(origin)
+        })
+        .allow_any_method()
+        .allow_any_header()
+}
+
+/// Checks that an --allowed-origin value looks like a browser Origin header (scheme://host[:port]).
+///
+/// actix-cors panics on "*" and fails at worker startup on values it can't parse, so these are caught up front.
+fn validate_origin(origin: String) -> Result<(), String> {
+    let origin_pattern =
+        Regex::new(r"^[A-Za-z][A-Za-z0-9+.\-]*://([A-Za-z0-9.\-]+|\[[0-9A-Fa-f:.]+\])(:[0-9]{1,5})?$")
+            .unwrap();
+
+    if origin == "*" {
+        return Err("\"*\" is not a valid origin; omit --allowed-origin to allow any origin.".to_owned());
+    }
+
+    if !origin_pattern.is_match(&origin) {
+        return Err(format!(
+            "\"{}\" is not a valid origin; expected scheme://host[:port] with no path or trailing slash.",
+            origin
+        ));
+    }
+
+    Ok(())
+}
+
 async fn run_server(
     stdin: Vec<u8>,
     socket_address: &str,
+    allowed_origins: Vec<String>,
+    static_dir: Option<String>,
 ) -> io::Result<()> {
     let html = include_str!("../ui/index.html");
     let css = include_str!("../ui/out.css");
@@ -97,6 +142,7 @@ async fn run_server(
     let js_map = include_str!("../ui/out.js.map");
 
     let (tx, rx) = mpsc::channel::<()>();
+    let server_socket_address = socket_address.to_owned();
 
     let server = actix_web::HttpServer::new(move || {
         actix_web::App::new()
@@ -113,8 +159,17 @@ async fn run_server(
             .service(index_css)
             .service(index_js)
             .service(index_js_map)
+            .configure(|config| {
+                if let Some(ref static_dir) = static_dir {
+                    config.service(
+                        Files::new("/ui", static_dir)
+                            .index_file("index.html")
+                            .redirect_to_slash_directory(),
+                    );
+                }
+            })
             .wrap(Logger::default())
-            .wrap(Cors::permissive())
+            .wrap(cors(&server_socket_address, &allowed_origins))
     })
     .bind(socket_address)?
     .run();
@@ -151,6 +206,18 @@ async fn main() {
         .version("1.7.0")
         .author("Jim Berlage <jamesberlage@gmail.com>")
         .about("Allows users to view process output as a spreadsheet.")
+        .arg(
+            Arg::with_name("host")
+                .long("host")
+                .help(
+                    "The address vawk should listen on.",
+                )
+                .default_value("127.0.0.1")
+                .env("VAWK_HOST")
+                .takes_value(true)
+                .value_name("HOST")
+                .required(false),
+        )
         .arg(
             Arg::with_name("port")
                 .long("port")
@@ -159,21 +226,138 @@ async fn main() {
                     "The port vawk should run on.",
                 )
                 .default_value("6846")
+                .env("VAWK_PORT")
                 .takes_value(true)
                 .value_name("PORT")
                 .required(false),
         )
+        .arg(
+            Arg::with_name("allowed-origin")
+                .long("allowed-origin")
+                .help(
+                    "An origin allowed to make cross-origin requests to vawk.  May be given multiple times.  If omitted, any origin is allowed.",
+                )
+                .takes_value(true)
+                .multiple(true)
+                .number_of_values(1)
+                .value_name("ORIGIN")
+                .validator(validate_origin)
+                .required(false),
+        )
+        .arg(
+            Arg::with_name("static-dir")
+                .long("static-dir")
+                .help(
+                    "A directory of static files to serve under /ui/, for custom dashboards.",
+                )
+                .takes_value(true)
+                .value_name("DIR")
+                .required(false),
+        )
         .get_matches();
+    let host = matches.value_of("host").unwrap();
     let port = matches.value_of("port").unwrap();
+    let allowed_origins = matches
+        .values_of("allowed-origin")
+        .map(|origins| origins.map(|origin| origin.to_owned()).collect())
+        .unwrap_or_default();
+    let static_dir = matches.value_of("static-dir").map(|dir| dir.to_owned());
+    // actix-files falls back to the working directory when given a bad path, so refuse to start instead.
+    if let Some(ref dir) = static_dir {
+        match fs::metadata(dir).map(|metadata| metadata.is_dir()) {
+            Ok(true) => {}
+            Ok(false) => {
+                log::error!("The static directory \"{}\" is not a directory.", dir);
+                process::exit(1);
+            }
+            Err(error) => {
+                log::error!("Could not read the static directory \"{}\":\n{}", dir, error);
+                process::exit(1);
+            }
+        }
+    }
 
     let mut stdin = vec![];
     if let Err(error) = io::stdin().read_to_end(&mut stdin) {
         log::error!("Failed to read command input:\n{}", error);
     }
 
-    let socket_address = format!("127.0.0.1:{}", port);
+    // IPv6 hosts need brackets, both for binding and for the URL and origin the GUI is opened at.
+    let socket_address = if host.contains(':') {
+        format!("[{}]:{}", host, port)
+    } else {
+        format!("{}:{}", host, port)
+    };
 
-    if let Err(error) = run_server(stdin, &socket_address).await {
+    if let Err(error) = run_server(stdin, &socket_address, allowed_origins, static_dir).await {
         log::error!("Failed to start server:\n{}", error);
+        // Exit with a failure code so that scripts wrapping vawk can tell the bind (or GUI launch) failed.
+        process::exit(1);
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use actix_web::dev::Service;
+    use actix_web::http::header;
+    use actix_web::{test, web, App, HttpResponse};
+
+    const SOCKET_ADDRESS: &str = "127.0.0.1:6846";
+
+    /// Sends a GET to /ws/ with the given Origin, returning the Access-Control-Allow-Origin it comes back with, if any.
+    ///
+    /// Rejected origins may surface either as an error response or as a service error, so both are treated as "not allowed".
+    fn allowed_origin_for(origin: &str) -> Option<String> {
+        let allowed_origins = vec!["https://dashboard.example.com".to_owned()];
+        let origin = origin.to_owned();
+
+        actix_web::rt::System::new("test").block_on(async move {
+            let mut app = test::init_service(
+                App::new()
+                    .wrap(super::cors(SOCKET_ADDRESS, &allowed_origins))
+                    .route("/ws/", web::get().to(|| HttpResponse::Ok())),
+            )
+            .await;
+            let request = test::TestRequest::get()
+                .uri("/ws/")
+                .header(header::ORIGIN, origin)
+                .to_request();
+
+            match app.call(request).await {
+                Ok(response) if response.status().is_success() => response
+                    .headers()
+                    .get(header::ACCESS_CONTROL_ALLOW_ORIGIN)
+                    .map(|value| value.to_str().unwrap().to_owned()),
+                _ => None,
+            }
+        })
+    }
+
+    #[test]
+    fn cors_allows_listed_origin() {
+        assert_eq!(
+            allowed_origin_for("https://dashboard.example.com"),
+            Some("https://dashboard.example.com".to_owned())
+        );
+    }
+
+    #[test]
+    fn cors_rejects_unlisted_origin() {
+        assert_eq!(allowed_origin_for("https://elsewhere.example.com"), None);
+    }
+
+    #[test]
+    fn cors_allows_gui_origin() {
+        let gui_origin = format!("http://{}", SOCKET_ADDRESS);
+        assert_eq!(allowed_origin_for(&gui_origin), Some(gui_origin));
+    }
+
+    #[test]
+    fn validate_origin() {
+        assert!(super::validate_origin("https://dashboard.example.com".to_owned()).is_ok());
+        assert!(super::validate_origin("http://[::1]:6846".to_owned()).is_ok());
+        assert!(super::validate_origin("*".to_owned()).is_err());
+        assert!(super::validate_origin("https://dashboard.example.com/".to_owned()).is_err());
+        assert!(super::validate_origin("dashboard.example.com".to_owned()).is_err());
     }
 }